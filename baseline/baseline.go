@@ -0,0 +1,121 @@
+// Package baseline snapshots known dependency-rule violations so an
+// existing codebase can adopt go-archiguard without having to fix its
+// entire legacy debt up front: only violations that are new relative to
+// the baseline fail the build.
+package baseline
+
+import (
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fingerprint identifies a single violation independent of scan order,
+// so the same violation compares equal across runs. It must carry every
+// field that changes which violation "shape" the reporter actually
+// emits: IsTest and Context, in particular, distinguish a TEST LAYER
+// VIOLATION from the identically-shaped production one, and a violation
+// only reproduced under a given --tag-contexts combination from the
+// primary-context one. Dropping either lets an unrelated new violation
+// fingerprint-collide with an existing baseline entry and ride in for
+// free (or a real fix go unreported as "fixed").
+type Fingerprint struct {
+	FromPackage string `yaml:"from_package"`
+	FromLayer   string `yaml:"from_layer"`
+	To          string `yaml:"to"` // offending layer or external package
+	RuleID      string `yaml:"rule_id"`
+	IsTest      bool   `yaml:"is_test"`
+	Context     string `yaml:"context,omitempty"` // tag-context label; empty for the primary context
+}
+
+// Baseline is the on-disk snapshot written by --update-baseline and
+// consulted by subsequent runs passing --baseline.
+type Baseline struct {
+	Violations []Fingerprint `yaml:"violations"`
+}
+
+// Load reads a baseline file. A missing file is reported via the
+// ordinary os.IsNotExist error so callers can treat "no baseline yet"
+// as an empty one.
+func Load(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bl Baseline
+	if err := yaml.Unmarshal(data, &bl); err != nil {
+		return nil, err
+	}
+	return &bl, nil
+}
+
+// Save writes fingerprints to path as stable-sorted YAML, so re-running
+// --update-baseline on an unchanged violation set produces an identical
+// diff-free file.
+func Save(path string, fingerprints []Fingerprint) error {
+	sorted := append([]Fingerprint{}, fingerprints...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.FromPackage != b.FromPackage {
+			return a.FromPackage < b.FromPackage
+		}
+		if a.FromLayer != b.FromLayer {
+			return a.FromLayer < b.FromLayer
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		if a.RuleID != b.RuleID {
+			return a.RuleID < b.RuleID
+		}
+		if a.Context != b.Context {
+			return a.Context < b.Context
+		}
+		return !a.IsTest && b.IsTest
+	})
+
+	data, err := yaml.Marshal(&Baseline{Violations: sorted})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Contains reports whether fp was recorded in the baseline. A nil
+// Baseline contains nothing.
+func (b *Baseline) Contains(fp Fingerprint) bool {
+	if b == nil {
+		return false
+	}
+	for _, existing := range b.Violations {
+		if existing == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff splits current against the baseline: unmatched is everything in
+// current not present in the baseline (these must still fail the
+// build), and fixed is everything in the baseline no longer present in
+// current (candidates for cleanup under --strict-baseline).
+func Diff(b *Baseline, current []Fingerprint) (unmatched []Fingerprint, fixed []Fingerprint) {
+	seen := make(map[Fingerprint]bool, len(current))
+	for _, fp := range current {
+		seen[fp] = true
+		if !b.Contains(fp) {
+			unmatched = append(unmatched, fp)
+		}
+	}
+	if b == nil {
+		return unmatched, nil
+	}
+	for _, fp := range b.Violations {
+		if !seen[fp] {
+			fixed = append(fixed, fp)
+		}
+	}
+	return unmatched, fixed
+}
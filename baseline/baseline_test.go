@@ -0,0 +1,135 @@
+package baseline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	fingerprints := []Fingerprint{
+		{FromPackage: "app/handler", FromLayer: "app", To: "db", RuleID: "app->db"},
+		{FromPackage: "app/handler", FromLayer: "app", To: "domain", RuleID: "app->domain"},
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	if err := Save(path, fingerprints); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	bl, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(bl.Violations) != len(fingerprints) {
+		t.Fatalf("got %d violations, want %d", len(bl.Violations), len(fingerprints))
+	}
+	for _, fp := range fingerprints {
+		if !bl.Contains(fp) {
+			t.Errorf("baseline does not contain %+v after round trip", fp)
+		}
+	}
+}
+
+func TestSaveIsStableSorted(t *testing.T) {
+	unsorted := []Fingerprint{
+		{FromPackage: "b", FromLayer: "app", To: "db", RuleID: "app->db"},
+		{FromPackage: "a", FromLayer: "app", To: "db", RuleID: "app->db"},
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	if err := Save(path, unsorted); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// Saving the same fingerprints again, in a different input order,
+	// must produce byte-identical output so repeated --update-baseline
+	// runs don't generate diff-only-in-order churn.
+	reversed := []Fingerprint{unsorted[1], unsorted[0]}
+	if err := Save(path, reversed); err != nil {
+		t.Fatalf("Save (reversed): %v", err)
+	}
+	second, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	if string(first) != string(second) {
+		t.Errorf("Save output is not stable across input order:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "does-not-exist.yaml"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Load of a missing file: got err %v, want os.IsNotExist", err)
+	}
+}
+
+func TestContainsNilBaseline(t *testing.T) {
+	var bl *Baseline
+	if bl.Contains(Fingerprint{FromPackage: "x"}) {
+		t.Error("nil *Baseline should not contain anything")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	bl := &Baseline{Violations: []Fingerprint{
+		{FromPackage: "app/handler", FromLayer: "app", To: "db", RuleID: "app->db"},         // still present below
+		{FromPackage: "app/handler", FromLayer: "app", To: "legacy", RuleID: "app->legacy"}, // fixed: absent below
+	}}
+
+	current := []Fingerprint{
+		{FromPackage: "app/handler", FromLayer: "app", To: "db", RuleID: "app->db"},
+		{FromPackage: "app/handler", FromLayer: "app", To: "cache", RuleID: "app->cache"}, // new: not in baseline
+	}
+
+	unmatched, fixed := Diff(bl, current)
+
+	if len(unmatched) != 1 || unmatched[0].To != "cache" {
+		t.Errorf("unmatched = %+v, want exactly the new `cache` violation", unmatched)
+	}
+	if len(fixed) != 1 || fixed[0].To != "legacy" {
+		t.Errorf("fixed = %+v, want exactly the no-longer-reproduced `legacy` entry", fixed)
+	}
+}
+
+func TestDiffDistinguishesTestFromProductionViolations(t *testing.T) {
+	// Baselining a production-only "app->infra" violation must not
+	// swallow a newly-introduced test violation with the same
+	// package/layer/target/rule: IsTest has to be part of the shape.
+	bl := &Baseline{Violations: []Fingerprint{
+		{FromPackage: "app/handler", FromLayer: "app", To: "infra", RuleID: "app->infra", IsTest: false},
+	}}
+
+	current := []Fingerprint{
+		{FromPackage: "app/handler", FromLayer: "app", To: "infra", RuleID: "app->infra", IsTest: false},
+		{FromPackage: "app/handler", FromLayer: "app", To: "infra", RuleID: "app->infra", IsTest: true},
+	}
+
+	unmatched, fixed := Diff(bl, current)
+
+	if len(unmatched) != 1 || !unmatched[0].IsTest {
+		t.Errorf("unmatched = %+v, want exactly the new test-code violation", unmatched)
+	}
+	if len(fixed) != 0 {
+		t.Errorf("fixed = %+v, want none: the production violation is still present", fixed)
+	}
+}
+
+func TestDiffNilBaseline(t *testing.T) {
+	current := []Fingerprint{{FromPackage: "app/handler", FromLayer: "app", To: "db", RuleID: "app->db"}}
+
+	unmatched, fixed := Diff(nil, current)
+
+	if len(unmatched) != len(current) {
+		t.Errorf("unmatched = %+v, want everything (no baseline to match against)", unmatched)
+	}
+	if fixed != nil {
+		t.Errorf("fixed = %+v, want nil with no baseline", fixed)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cachedImport is the on-disk form of an import: a file path plus a line/
+// column, persisted instead of a full token.Position since Offset isn't
+// meaningful once reloaded from a different token.FileSet.
+type cachedImport struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// cacheEntry is what importCache persists per source file, similar in
+// spirit to the go build cache: everything parseProject needs to skip
+// re-parsing an unchanged file.
+type cacheEntry struct {
+	ModTime     int64          `json:"mod_time"`
+	Size        int64          `json:"size"`
+	ConfigHash  string         `json:"config_hash"`
+	PackageName string         `json:"package_name"`
+	TagExpr     string         `json:"tag_expr"`
+	Imports     []cachedImport `json:"imports"`
+}
+
+// importCache is a persistent, on-disk cache keyed by file path, guarded
+// by a mutex so concurrent workers in parseProject can share it safely.
+// A nil *importCache is valid and simply disables caching.
+type importCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+// openImportCache loads the cache file from cacheDir, if any. cacheDir
+// == "" disables caching entirely (openImportCache returns nil).
+// A missing or corrupt cache file is not an error: it just means every
+// file below will be treated as a miss and reparsed.
+func openImportCache(cacheDir string) *importCache {
+	if cacheDir == "" {
+		return nil
+	}
+
+	ic := &importCache{
+		path:    filepath.Join(cacheDir, "archiguard-cache.json"),
+		entries: make(map[string]cacheEntry),
+	}
+	if data, err := os.ReadFile(ic.path); err == nil {
+		_ = json.Unmarshal(data, &ic.entries)
+	}
+	return ic
+}
+
+// lookup returns the cached entry for path if it is still valid, i.e.
+// mtime, size and configHash all match the current run.
+func (ic *importCache) lookup(path string, info os.FileInfo, configHash string) (cacheEntry, bool) {
+	if ic == nil {
+		return cacheEntry{}, false
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	entry, ok := ic.entries[path]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if entry.ModTime != info.ModTime().UnixNano() || entry.Size != info.Size() || entry.ConfigHash != configHash {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store records entry for path, stamping it with the file's current
+// mtime/size and the run's configHash so a later lookup can validate it.
+func (ic *importCache) store(path string, info os.FileInfo, configHash string, entry cacheEntry) {
+	if ic == nil {
+		return
+	}
+
+	entry.ModTime = info.ModTime().UnixNano()
+	entry.Size = info.Size()
+	entry.ConfigHash = configHash
+
+	ic.mu.Lock()
+	ic.entries[path] = entry
+	ic.dirty = true
+	ic.mu.Unlock()
+}
+
+// flush persists the cache to disk if anything changed during this run.
+func (ic *importCache) flush() error {
+	if ic == nil || !ic.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ic.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ic.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(ic.path, data, 0o644)
+}
+
+// configHash hashes the parts of Config and the build context that
+// affect parsing and classification, so editing dependency/layer rules
+// or switching GOOS/GOARCH/tags invalidates every cache entry.
+func configHash(config *Config, buildCtx *build.Context) string {
+	payload, _ := json.Marshal(struct {
+		Config    *Config
+		GOOS      string
+		GOARCH    string
+		BuildTags []string
+	}{config, buildCtx.GOOS, buildCtx.GOARCH, buildCtx.BuildTags})
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// LayerGraph is the directed layer->layer dependency graph aggregated
+// across every package, used for cycle detection and transitive rule
+// checks. Examples records one offending package per edge (the
+// lexicographically smallest path) so cycle and DOT reports can point at
+// real code instead of just layer names.
+type LayerGraph struct {
+	Edges    map[string]map[string]bool
+	Examples map[string]map[string]string
+}
+
+// newLayerGraph builds the layer graph from every package's direct
+// LayerDeps. Self-edges (a layer depending on itself) are not cycles in
+// the sense this package cares about, so they're skipped.
+func newLayerGraph(packages map[string]*PackageInfo) *LayerGraph {
+	g := &LayerGraph{
+		Edges:    make(map[string]map[string]bool),
+		Examples: make(map[string]map[string]string),
+	}
+
+	for _, pkg := range packages {
+		for layer := range pkg.LayerDeps {
+			if layer == pkg.Layer {
+				continue
+			}
+			g.addEdge(pkg.Layer, layer, pkg.Path)
+		}
+	}
+
+	return g
+}
+
+func (g *LayerGraph) addEdge(from, to, examplePkg string) {
+	if g.Edges[from] == nil {
+		g.Edges[from] = make(map[string]bool)
+	}
+	g.Edges[from][to] = true
+
+	if g.Examples[from] == nil {
+		g.Examples[from] = make(map[string]string)
+	}
+	if existing, exists := g.Examples[from][to]; !exists || examplePkg < existing {
+		g.Examples[from][to] = examplePkg
+	}
+}
+
+// nodes returns every layer that appears as either endpoint of an edge,
+// sorted for deterministic iteration.
+func (g *LayerGraph) nodes() []string {
+	seen := make(map[string]bool)
+	for from, tos := range g.Edges {
+		seen[from] = true
+		for to := range tos {
+			seen[to] = true
+		}
+	}
+
+	nodes := make([]string, 0, len(seen))
+	for n := range seen {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// reachable reports whether to is reachable from from via a BFS over the
+// layer graph, used by `transitive: true` dependency rules.
+func (g *LayerGraph) reachable(from, to string) bool {
+	if from == to {
+		return false
+	}
+
+	visited := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for next := range g.Edges[n] {
+			if next == to {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
+
+// tarjanSCC runs Tarjan's strongly-connected-components algorithm over
+// the layer graph and returns every component in the order the algorithm
+// completes them (reverse topological order of the component graph).
+// Components of size 1 are ordinary unless the layer has a self-edge
+// (which newLayerGraph never produces), so callers filter for size > 1
+// to find real cycles.
+func (g *LayerGraph) tarjanSCC() [][]string {
+	index := 0
+	indices := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+
+	var strongconnect func(v string)
+	strongconnect = func(v string) {
+		indices[v] = index
+		lowlink[v] = index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		neighbors := make([]string, 0, len(g.Edges[v]))
+		for w := range g.Edges[v] {
+			neighbors = append(neighbors, w)
+		}
+		sort.Strings(neighbors)
+
+		for _, w := range neighbors {
+			if _, visited := indices[w]; !visited {
+				strongconnect(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] {
+				if indices[w] < lowlink[v] {
+					lowlink[v] = indices[w]
+				}
+			}
+		}
+
+		if lowlink[v] == indices[v] {
+			var component []string
+			for {
+				n := len(stack) - 1
+				w := stack[n]
+				stack = stack[:n]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+
+	for _, v := range g.nodes() {
+		if _, visited := indices[v]; !visited {
+			strongconnect(v)
+		}
+	}
+
+	return components
+}
+
+// writeGraphDOT exports the layer graph as Graphviz DOT, labeling each
+// edge with one example package that triggers it, so users can see
+// where to start refactoring before enabling a rule.
+func writeGraphDOT(path string, g *LayerGraph) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph layers {\n")
+	for _, from := range g.nodes() {
+		tos := make([]string, 0, len(g.Edges[from]))
+		for to := range g.Edges[from] {
+			tos = append(tos, to)
+		}
+		sort.Strings(tos)
+		for _, to := range tos {
+			fmt.Fprintf(&buf, "  %q -> %q [label=%q];\n", from, to, g.Examples[from][to])
+		}
+	}
+	buf.WriteString("}\n")
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func packagesWithLayerDeps(layerDeps map[string][]string) map[string]*PackageInfo {
+	packages := make(map[string]*PackageInfo)
+	for layer, deps := range layerDeps {
+		pkg := newPackageInfo("example.com/app/pkg", "example.com/app", layer)
+		for _, dep := range deps {
+			pkg.LayerDeps[dep] = ImportRef{Path: dep}
+		}
+		packages[layer+"#pkg"] = pkg
+	}
+	return packages
+}
+
+func TestNewLayerGraphSkipsSelfEdges(t *testing.T) {
+	packages := packagesWithLayerDeps(map[string][]string{
+		"app": {"app", "domain"},
+	})
+
+	g := newLayerGraph(packages)
+
+	if g.Edges["app"]["app"] {
+		t.Error("newLayerGraph recorded a self-edge app -> app")
+	}
+	if g.reachable("app", "app") {
+		t.Error("reachable(app, app) should be false: self-edges aren't cycles this package cares about")
+	}
+	if !g.reachable("app", "domain") {
+		t.Error("reachable(app, domain) should be true")
+	}
+}
+
+func TestLayerGraphReachableIsTransitive(t *testing.T) {
+	packages := packagesWithLayerDeps(map[string][]string{
+		"app":    {"domain"},
+		"domain": {"db"},
+	})
+
+	g := newLayerGraph(packages)
+
+	if !g.reachable("app", "db") {
+		t.Error("db should be transitively reachable from app via domain")
+	}
+	if g.reachable("db", "app") {
+		t.Error("app should not be reachable from db: there is no edge that way")
+	}
+}
+
+func TestTarjanSCCFindsCycle(t *testing.T) {
+	packages := packagesWithLayerDeps(map[string][]string{
+		"app":    {"domain"},
+		"domain": {"app"},
+	})
+
+	g := newLayerGraph(packages)
+	sccs := g.tarjanSCC()
+
+	var cyclic []string
+	for _, scc := range sccs {
+		if len(scc) > 1 {
+			cyclic = scc
+		}
+	}
+	if len(cyclic) != 2 || cyclic[0] != "app" || cyclic[1] != "domain" {
+		t.Errorf("tarjanSCC() = %v, want a single 2-element cycle [app domain]", sccs)
+	}
+}
+
+func TestTarjanSCCNoCycleInDAG(t *testing.T) {
+	packages := packagesWithLayerDeps(map[string][]string{
+		"app":    {"domain"},
+		"domain": {"db"},
+	})
+
+	g := newLayerGraph(packages)
+	for _, scc := range g.tarjanSCC() {
+		if len(scc) > 1 {
+			t.Errorf("tarjanSCC() found a cycle %v in an acyclic graph", scc)
+		}
+	}
+}
+
+func TestCheckLayerCyclesUsesExamplePackage(t *testing.T) {
+	packages := map[string]*PackageInfo{}
+	app := newPackageInfo("example.com/app/handler", "example.com/app", "app")
+	app.LayerDeps["domain"] = ImportRef{Path: "example.com/app/domain"}
+	packages["example.com/app/handler"] = app
+
+	domain := newPackageInfo("example.com/app/domain/svc", "example.com/app", "domain")
+	domain.LayerDeps["app"] = ImportRef{Path: "example.com/app/handler"}
+	packages["example.com/app/domain/svc"] = domain
+
+	graph := newLayerGraph(packages)
+	violations := checkLayerCycles(graph)
+
+	if len(violations) != 1 {
+		t.Fatalf("got %d cycle violations, want 1", len(violations))
+	}
+	v := violations[0]
+	if v.RuleID != cycleRuleID {
+		t.Errorf("RuleID = %q, want %q", v.RuleID, cycleRuleID)
+	}
+	if v.Package == "" {
+		t.Error("Package should be populated from graph.Examples, not left blank")
+	}
+}
+
+func TestWriteGraphDOT(t *testing.T) {
+	packages := packagesWithLayerDeps(map[string][]string{
+		"app": {"domain"},
+	})
+	g := newLayerGraph(packages)
+
+	path := filepath.Join(t.TempDir(), "layers.dot")
+	if err := writeGraphDOT(path, g); err != nil {
+		t.Fatalf("writeGraphDOT: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	dot := string(data)
+	if !strings.HasPrefix(dot, "digraph layers {") {
+		t.Errorf("DOT output does not start with the digraph header: %q", dot)
+	}
+	if !strings.Contains(dot, `"app" -> "domain"`) {
+		t.Errorf("DOT output missing expected edge app -> domain: %q", dot)
+	}
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"go/build"
+	"go/build/constraint"
 	"go/parser"
 	"go/token"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/bmatcuk/doublestar/v4"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
+
+	"github.com/speedoops/go-archiguard/baseline"
 )
 
 const (
@@ -22,9 +32,25 @@ const (
 
 // Config 定义增强版 YAML 配置结构
 type Config struct {
-	Layers          map[string]LayerConfig `yaml:"layers"`
-	DependencyRules []DependencyRule       `yaml:"dependency_rules"`
-	ExcludeDirs     []string               `yaml:"exclude_dirs"` // 新增排除目录配置
+	Layers              map[string]LayerConfig `yaml:"layers"`
+	DependencyRules     []DependencyRule       `yaml:"dependency_rules"`
+	TestDependencyRules []DependencyRule       `yaml:"test_dependency_rules"` // 叠加在 dependency_rules 之上，仅用于测试代码
+	ExcludeDirs         []string               `yaml:"exclude_dirs"`          // 新增排除目录配置
+	Build               BuildConfig            `yaml:"build"`                 // 构建上下文配置（GOOS/GOARCH/tags）
+}
+
+// BuildConfig 对应 go/build.Context 中与本工具相关的子集，
+// 决定一个 `.go` 文件在分析时是否被纳入。
+type BuildConfig struct {
+	GOOS   string   `yaml:"goos"`
+	GOARCH string   `yaml:"goarch"`
+	Tags   []string `yaml:"tags"`
+	// TagContexts 声明额外的 tag 组合：每个条目在和 --tag-contexts 合并后，
+	// 都会重新完整跑一遍解析和检查（见 runTagContext）——文件自身的
+	// `//go:build` 约束是按当前激活的 tag 集合求值的，只有重新解析才能
+	// 看到被某个不在主上下文里的 tag 挡住的文件。GOOS/GOARCH 对这些条目
+	// 始终沿用上面的 GOOS/GOARCH，只有 tags 维度在变化。
+	TagContexts [][]string `yaml:"tag_contexts"`
 }
 
 type LayerConfig struct {
@@ -32,41 +58,444 @@ type LayerConfig struct {
 }
 
 type DependencyRule struct {
-	From  string `yaml:"from"`
-	To    string `yaml:"to"`
-	Allow bool   `yaml:"allow"`
+	From       string `yaml:"from"`
+	To         string `yaml:"to"`
+	Allow      bool   `yaml:"allow"`
+	When       string `yaml:"when"`       // 构建标签表达式，如 "linux && !test"；为空表示始终生效
+	Transitive bool   `yaml:"transitive"` // true 时按层依赖图的可达性（BFS）而非直接边判断
+}
+
+// ImportRef 记录一条 import 及其在源码中的位置，使违规报告（尤其是
+// SARIF）能精确指向出问题的 import 语句，而不仅仅是所在的包。
+type ImportRef struct {
+	Path string
+	Pos  token.Position
 }
 
 type PackageInfo struct {
 	Path         string
 	Module       string
 	Layer        string
-	Imports      []string
-	LayerDeps    map[string]bool // 层依赖关系
-	ExternalDeps map[string]bool // 外部依赖记录
+	Imports      []ImportRef
+	ImportsByTag map[string][]ImportRef // 按文件自身 //go:build 表达式分组的 import，"" 为无约束
+	LayerDeps    map[string]ImportRef   // 层 -> 触发该层依赖的一个 import（用于定位）
+	ExternalDeps map[string]ImportRef   // 外部包 -> 触发该外部依赖的一个 import
+
+	// 以下字段仅由 `_test.go` 文件贡献。同包测试（package foo）与生产代码
+	// 共用同一个 PackageInfo，但 import 记录在这里，不污染生产层依赖；
+	// 外部测试包（package foo_test）对 import 而言是独立包，会落在
+	// Path+"_test" 这个单独的 PackageInfo 上。
+	TestImports      []ImportRef
+	TestImportsByTag map[string][]ImportRef
+	TestLayerDeps    map[string]ImportRef
+	TestExternalDeps map[string]ImportRef
+}
+
+// newPackageInfo 构造一个字段均已初始化的 PackageInfo。
+func newPackageInfo(path, module, layer string) *PackageInfo {
+	return &PackageInfo{
+		Path:             path,
+		Module:           module,
+		Layer:            layer,
+		Imports:          []ImportRef{},
+		ImportsByTag:     make(map[string][]ImportRef),
+		LayerDeps:        make(map[string]ImportRef),
+		ExternalDeps:     make(map[string]ImportRef),
+		TestImports:      []ImportRef{},
+		TestImportsByTag: make(map[string][]ImportRef),
+		TestLayerDeps:    make(map[string]ImportRef),
+		TestExternalDeps: make(map[string]ImportRef),
+	}
+}
+
+// MainModule 对应 go.work 中一个 `use` 指令解析出的主模块，
+// 类似 cmd/go 内部 modload 包里的 MainModules 概念。
+type MainModule struct {
+	Path string // go.mod 中声明的模块路径
+	Dir  string // 模块根目录（绝对路径）
+}
+
+// Workspace 持有一个 go.work 工作区内的全部主模块，以及
+// go.work 和各成员 go.mod 中收集到的 replace 指令，
+// 用于在分类 import 时判断其是否属于本工作区。
+type Workspace struct {
+	MainModules []MainModule
+	Replace     map[string]string // 旧模块路径 -> 新模块路径（本地目录已换算为其有效模块路径）
+}
+
+// loadWorkspace 在 root 下查找 go.work 文件并解析出 Workspace。
+// 如果 root 下不存在 go.work，返回 (nil, nil)，调用方应回退到
+// 按文件逐个查找最近 go.mod 的单模块行为。
+func loadWorkspace(root string) (*Workspace, error) {
+	workPath := filepath.Join(root, "go.work")
+	data, err := os.ReadFile(workPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read go.work: %v", err)
+	}
+
+	workFile, err := modfile.ParseWork(workPath, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go.work: %v", err)
+	}
+
+	ws := &Workspace{Replace: make(map[string]string)}
+	for _, use := range workFile.Use {
+		useDir := use.Path
+		if !filepath.IsAbs(useDir) {
+			useDir = filepath.Join(root, useDir)
+		}
+
+		modPath := filepath.Join(useDir, "go.mod")
+		modData, err := os.ReadFile(modPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read go.mod for workspace module %s: %v", use.Path, err)
+		}
+
+		modFile, err := modfile.Parse(modPath, modData, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse go.mod for workspace module %s: %v", use.Path, err)
+		}
+
+		ws.MainModules = append(ws.MainModules, MainModule{Path: modFile.Module.Mod.Path, Dir: useDir})
+		if err := collectReplaces(ws.Replace, modFile.Replace, useDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := collectReplaces(ws.Replace, workFile.Replace, root); err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// collectReplaces 将 modfile 解析出的 replace 指令合并进 dst，
+// 后解析的文件（go.work）优先级更高，会覆盖 go.mod 中的同名条目。
+// replace 指令以模块路径为键（`Old.Path`），而不是完整 import 路径，
+// 所以这里只记录旧模块路径 -> 新模块路径这一层映射；真正按 import 路径
+// 做前缀匹配、拼回子包路径的工作在 resolveImportPath 里完成。
+// 当 `New` 指向本地目录（`New.Version == ""`）时，通过读取该目录（或其
+// 祖先目录）的 go.mod 把它换算成有效模块路径（含子目录后缀），这样
+// replace 到工作区内某个已 use 模块的子目录时也能正确解析。baseDir 是
+// replace 指令所在文件（go.work 或成员模块的 go.mod）的目录，相对路径
+// 以它为基准展开。
+func collectReplaces(dst map[string]string, replaces []*modfile.Replace, baseDir string) error {
+	for _, r := range replaces {
+		newPath := r.New.Path
+		if r.New.Version == "" {
+			dir := newPath
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(baseDir, dir)
+			}
+			resolved, err := moduleAndSubpathForDir(dir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve replace %s => %s: %v", r.Old.Path, r.New.Path, err)
+			}
+			newPath = resolved
+		}
+		dst[r.Old.Path] = newPath
+	}
+	return nil
+}
+
+// moduleAndSubpathForDir 返回 dir 的有效模块路径：从 dir 向上找到最近的
+// go.mod，取其模块路径，再拼上 dir 相对该 go.mod 目录的子路径。用于把
+// replace 指令里的本地目录换算成可以和 import 路径比较的模块路径。
+func moduleAndSubpathForDir(dir string) (string, error) {
+	modDir, modPath, err := findNearestModule(filepath.Join(dir, "placeholder.go"))
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(modDir, dir)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return modPath, nil
+	}
+	return modPath + "/" + filepath.ToSlash(rel), nil
+}
+
+// resolveImportPath 对 imp 应用工作区的 replace 指令：找到 ws.Replace 中
+// 能作为 imp 模块路径前缀匹配到的最长 Old 路径（必须匹配到路径分段边界，
+// 而不是字符串前缀），把该前缀替换为对应的新模块路径，子包后缀原样保留。
+// 没有任何 replace 条目匹配时，imp 原样返回。
+func resolveImportPath(imp string, ws *Workspace) string {
+	if ws == nil {
+		return imp
+	}
+
+	best := ""
+	for old := range ws.Replace {
+		if imp != old && !strings.HasPrefix(imp, old+"/") {
+			continue
+		}
+		if len(old) > len(best) {
+			best = old
+		}
+	}
+	if best == "" {
+		return imp
+	}
+	return ws.Replace[best] + imp[len(best):]
+}
+
+// resolveModule 返回 path 所属的模块根目录与模块路径。
+// 工作区存在时优先按 MainModules 匹配；否则退化为原先的
+// findNearestModule 单模块查找逻辑。
+func resolveModule(path string, ws *Workspace) (modDir string, modPath string, err error) {
+	if ws != nil {
+		for _, m := range ws.MainModules {
+			rel, err := filepath.Rel(m.Dir, path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return m.Dir, m.Path, nil
+			}
+		}
+	}
+	return findNearestModule(path)
+}
+
+// newBuildContext 基于 YAML `build:` 配置和命令行覆盖值构造一个
+// go/build.Context，用于决定文件是否参与本次分析（GOOS/GOARCH 后缀、
+// //go:build 与旧式 // +build 约束均由 Context.MatchFile 处理）。
+func newBuildContext(config *Config, goos, goarch, tags string) *build.Context {
+	ctx := build.Default
+
+	if config.Build.GOOS != "" {
+		ctx.GOOS = config.Build.GOOS
+	}
+	if goos != "" {
+		ctx.GOOS = goos
+	}
+	if config.Build.GOARCH != "" {
+		ctx.GOARCH = config.Build.GOARCH
+	}
+	if goarch != "" {
+		ctx.GOARCH = goarch
+	}
+
+	buildTags := append([]string{}, config.Build.Tags...)
+	if tags != "" {
+		buildTags = append(buildTags, strings.Split(tags, ",")...)
+	}
+	ctx.BuildTags = buildTags
+
+	return &ctx
+}
+
+// activeTags 汇总当前构建上下文里所有为真的标签，供 DependencyRule.When
+// 表达式求值使用：GOOS、GOARCH、配置/命令行附加的自定义 tags，以及 cgo。
+func activeTags(ctx *build.Context) map[string]bool {
+	tags := map[string]bool{
+		ctx.GOOS:   true,
+		ctx.GOARCH: true,
+	}
+	for _, tag := range ctx.BuildTags {
+		tags[tag] = true
+	}
+	if ctx.CgoEnabled {
+		tags["cgo"] = true
+	}
+	return tags
+}
+
+// evalWhen 判断规则的 `when` 标签表达式在当前构建上下文下是否成立。
+// 空表达式视为始终成立。
+func evalWhen(when string, tags map[string]bool) (bool, error) {
+	if when == "" {
+		return true, nil
+	}
+	expr, err := constraint.Parse("//go:build " + when)
+	if err != nil {
+		return false, fmt.Errorf("invalid when expression %q: %v", when, err)
+	}
+	return expr.Eval(func(tag string) bool { return tags[tag] }), nil
+}
+
+// fileBuildConstraint 提取一个 .go 文件自身携带的构建约束表达式
+// （`//go:build ...` 或旧式 `// +build ...`），用于按标签集对 import
+// 分组。文件不带约束时返回空字符串。
+func fileBuildConstraint(src []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break // 到达 package 子句或代码，约束只能出现在文件靠前的注释区
+		}
+		if constraint.IsGoBuild(line) || constraint.IsPlusBuild(line) {
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return "", err
+			}
+			return expr.String(), nil
+		}
+	}
+	return "", nil
 }
 
 func main() {
 	projectRoot := flag.String("project-root", "", "The root directory of the Go project")
 	configPath := flag.String("config", "config.yaml", "Path to the configuration YAML file")
+	goos := flag.String("goos", "", "GOOS to evaluate build constraints against (defaults to config/runtime)")
+	goarch := flag.String("goarch", "", "GOARCH to evaluate build constraints against (defaults to config/runtime)")
+	tags := flag.String("tags", "", "Comma-separated build tags, merged with config build.tags")
+	skipTests := flag.Bool("skip-tests", false, "Skip parsing and checking *_test.go files entirely")
+	format := flag.String("format", "text", "Output format: text, json, or sarif")
+	warnOnly := flag.Bool("warn-only", false, "Always exit 0, even if violations are found")
+	baselinePath := flag.String("baseline", "", "Path to a baseline file; violations recorded there are downgraded to informational")
+	updateBaseline := flag.Bool("update-baseline", false, "Write the current violations to --baseline instead of checking it")
+	strictBaseline := flag.Bool("strict-baseline", false, "Also fail when --baseline contains entries no longer reproduced (encourages cleanup)")
+	jobs := flag.Int("jobs", 0, "Number of concurrent file-parsing workers (<=0 means runtime.GOMAXPROCS)")
+	cacheDir := flag.String("cache-dir", "", "Directory holding the persistent import cache; empty disables caching")
+	graphPath := flag.String("graph", "", "Write a Graphviz DOT export of the aggregated layer dependency graph to this path")
+	tagContexts := flag.String("tag-contexts", "", "Comma-separated additional tag contexts to also check in this pass, each a '+'-joined tag group (e.g. \"cgo,windows+debug\"); merged with config build.tag_contexts")
 	flag.Parse()
 
 	if *projectRoot == "" || *configPath == "" {
 		log.Fatal("Both project-root and config must be specified")
 	}
+	if *format != "text" && *format != "json" && *format != "sarif" {
+		log.Fatalf("Invalid --format %q: must be text, json, or sarif", *format)
+	}
 
 	config, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	packages, err := parseProject(*projectRoot, config)
+	buildCtx := newBuildContext(config, *goos, *goarch, *tags)
+
+	packages, ws, err := parseProject(*projectRoot, config, buildCtx, *skipTests, *jobs, *cacheDir)
 	if err != nil {
 		log.Fatalf("Failed to parse project: %v", err)
 	}
 
-	analyzePackages(packages, config)
-	checkDependencies(packages, config)
+	analyzePackages(packages, ws, config)
+
+	graph := newLayerGraph(packages)
+	if *graphPath != "" {
+		if err := writeGraphDOT(*graphPath, graph); err != nil {
+			log.Fatalf("Failed to write layer graph: %v", err)
+		}
+	}
+
+	violations := checkDependencies(packages, config, activeTags(buildCtx), *skipTests, graph)
+
+	// 每个额外 tag 上下文都重新跑一遍 parseProject/checkDependencies：
+	// 一个文件自身的 `//go:build` 约束是按*当前*激活的 tag 集合求值的
+	// （processFile 里的 buildCtx.MatchFile），所以被某个不在主上下文里的
+	// tag 挡住的文件根本不会被解析，它的 import 也就无从在主上下文的
+	// 解析结果上"补算"出来——只有重新解析才能看见这些文件。
+	extraContexts := append(append([][]string{}, config.Build.TagContexts...), parseTagContexts(*tagContexts)...)
+	for _, extraTags := range extraContexts {
+		contextViolations, err := runTagContext(*projectRoot, config, buildCtx, extraTags, *skipTests, *jobs, *cacheDir)
+		if err != nil {
+			log.Fatalf("Failed to check tag context %s: %v", strings.Join(extraTags, "+"), err)
+		}
+
+		label := strings.Join(extraTags, "+")
+		for i := range contextViolations {
+			contextViolations[i].Context = label
+		}
+		violations = append(violations, contextViolations...)
+	}
+
+	sortViolations(violations)
+
+	// --update-baseline snapshots the current violations and stops there;
+	// it doesn't report or gate the build.
+	if *baselinePath != "" && *updateBaseline {
+		fingerprints := make([]baseline.Fingerprint, len(violations))
+		for i, v := range violations {
+			fingerprints[i] = fingerprintOf(v)
+		}
+		if err := baseline.Save(*baselinePath, fingerprints); err != nil {
+			log.Fatalf("Failed to write baseline: %v", err)
+		}
+		fmt.Printf("Updated baseline %s with %d violation(s)\n", *baselinePath, len(fingerprints))
+		return
+	}
+
+	staleBaselineEntries := false
+	if *baselinePath != "" {
+		bl, err := baseline.Load(*baselinePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to load baseline: %v", err)
+		}
+
+		current := make([]baseline.Fingerprint, len(violations))
+		for i, v := range violations {
+			current[i] = fingerprintOf(v)
+		}
+		unmatched, fixed := baseline.Diff(bl, current)
+
+		if known := len(violations) - len(unmatched); known > 0 {
+			fmt.Printf("[baseline] %d known violation(s) downgraded to informational\n", known)
+		}
+		if len(fixed) > 0 {
+			fmt.Printf("[baseline] %d entry(ies) fixed since the baseline was recorded:\n", len(fixed))
+			for _, fp := range fixed {
+				fmt.Printf("  - %s (%s) -> %s [%s]\n", fp.FromPackage, fp.FromLayer, fp.To, fp.RuleID)
+			}
+			staleBaselineEntries = true
+		}
+
+		violations = onlyUnmatched(violations, unmatched)
+	}
+
+	switch *format {
+	case "json":
+		if err := reportJSON(violations); err != nil {
+			log.Fatalf("Failed to write JSON report: %v", err)
+		}
+	case "sarif":
+		allRules := append(append([]DependencyRule{}, config.DependencyRules...), config.TestDependencyRules...)
+		if err := reportSARIF(allRules, violations); err != nil {
+			log.Fatalf("Failed to write SARIF report: %v", err)
+		}
+	default:
+		reportText(violations)
+	}
+
+	failBuild := len(violations) > 0 || (*strictBaseline && staleBaselineEntries)
+	if failBuild && !*warnOnly {
+		os.Exit(1)
+	}
+}
+
+// fingerprintOf derives a baseline.Fingerprint from a reported Violation.
+func fingerprintOf(v Violation) baseline.Fingerprint {
+	return baseline.Fingerprint{
+		FromPackage: v.Package,
+		FromLayer:   v.Layer,
+		To:          v.Target,
+		RuleID:      v.RuleID,
+		IsTest:      v.IsTest,
+		Context:     v.Context,
+	}
+}
+
+// onlyUnmatched keeps the violations whose fingerprint is in unmatched,
+// i.e. drops the ones the baseline already knows about.
+func onlyUnmatched(violations []Violation, unmatched []baseline.Fingerprint) []Violation {
+	keep := make(map[baseline.Fingerprint]bool, len(unmatched))
+	for _, fp := range unmatched {
+		keep[fp] = true
+	}
+
+	filtered := make([]Violation, 0, len(unmatched))
+	for _, v := range violations {
+		if keep[fingerprintOf(v)] {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
 }
 
 // loadConfig 加载增强版配置
@@ -84,11 +513,21 @@ func loadConfig(configFile string) (*Config, error) {
 	return &config, nil
 }
 
-// parseProject 实现目录排除和外部包检测
-func parseProject(root string, config *Config) (map[string]*PackageInfo, error) {
+// parseProject 实现目录排除和外部包检测。目录遍历本身仍是串行的（成本
+// 很低），真正昂贵的每文件解析交给一个按 jobs 大小（<=0 时退化为
+// runtime.GOMAXPROCS）的 worker 池并发处理；worker 间共享的 packages map
+// 由 mu 互斥保护。cacheDir 非空时命中持久化缓存的文件直接跳过解析。
+func parseProject(root string, config *Config, buildCtx *build.Context, skipTests bool, jobs int, cacheDir string) (map[string]*PackageInfo, *Workspace, error) {
 	packages := make(map[string]*PackageInfo)
+	var mu sync.Mutex
+
+	ws, err := loadWorkspace(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load go.work: %v", err)
+	}
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	var files []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -107,113 +546,458 @@ func parseProject(root string, config *Config) (map[string]*PackageInfo, error)
 		if !strings.HasSuffix(info.Name(), ".go") {
 			return nil
 		}
+		if skipTests && strings.HasSuffix(info.Name(), "_test.go") {
+			return nil
+		}
+
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk project: %v", err)
+	}
+
+	cache := openImportCache(cacheDir)
+	hash := configHash(config, buildCtx)
+
+	workerCount := jobs
+	if workerCount <= 0 {
+		workerCount = runtime.GOMAXPROCS(0)
+	}
 
-		// 获取模块信息
-		modDir, modPrefix, err := findNearestModule(path)
+	fileCh := make(chan string)
+	g, ctx := errgroup.WithContext(context.Background())
+	for i := 0; i < workerCount; i++ {
+		g.Go(func() error {
+			for path := range fileCh {
+				if err := processFile(path, config, buildCtx, ws, cache, hash, &mu, packages); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(fileCh)
+		for _, path := range files {
+			select {
+			case fileCh <- path:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cache.flush(); err != nil {
+		fmt.Printf("[warn] failed to persist import cache: %v\n", err)
+	}
+
+	return packages, ws, nil
+}
+
+// processFile 解析单个 .go 文件（或复用缓存结果）并把它贡献的 import
+// 合并进共享的 packages map，写入前以 mu 加锁。
+func processFile(path string, config *Config, buildCtx *build.Context, ws *Workspace, cache *importCache, hash string, mu *sync.Mutex, packages map[string]*PackageInfo) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	// GOOS/GOARCH 文件名后缀与 //go:build、// +build 约束均由
+	// MatchFile 评估，不满足当前构建上下文的文件直接跳过。
+	match, err := buildCtx.MatchFile(filepath.Dir(path), info.Name())
+	if err != nil {
+		return fmt.Errorf("failed to evaluate build constraints for %s: %v", path, err)
+	}
+	if !match {
+		return nil
+	}
+
+	// 获取模块信息（优先使用 go.work 工作区解析出的主模块）
+	modDir, modPrefix, err := resolveModule(path, ws)
+	if err != nil {
+		return fmt.Errorf("failed to find module for %s: %v", path, err)
+	}
+
+	var packageName, tagExpr string
+	var imports []cachedImport
+	if entry, ok := cache.lookup(path, info, hash); ok {
+		packageName, tagExpr, imports = entry.PackageName, entry.TagExpr, entry.Imports
+	} else {
+		src, err := os.ReadFile(path)
 		if err != nil {
-			return fmt.Errorf("failed to find module for %s: %v", path, err)
+			return fmt.Errorf("failed to read file %s: %v", path, err)
 		}
 
-		// 解析Go文件
 		fset := token.NewFileSet()
-		node, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		node, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
 		if err != nil {
 			return fmt.Errorf("failed to parse file %s: %v", path, err)
 		}
 
-		// 获取包相对路径
-		relPath, _ := filepath.Rel(modDir, filepath.Dir(path))
-		relPath = filepath.ToSlash(relPath)
-		pkgPath := fmt.Sprintf("%s/%s", modPrefix, relPath)
-		if _, exists := packages[pkgPath]; !exists {
-			packages[pkgPath] = &PackageInfo{
-				Path:         pkgPath,
-				Module:       modPrefix,
-				Layer:        getLayerForPackage(pkgPath, relPath, config),
-				Imports:      []string{},
-				LayerDeps:    make(map[string]bool),
-				ExternalDeps: make(map[string]bool),
-			}
+		// 提取文件自身携带的构建约束，用于按标签集对 import 分组
+		tagExpr, err = fileBuildConstraint(src)
+		if err != nil {
+			return fmt.Errorf("failed to parse build constraint for %s: %v", path, err)
 		}
 
-		// 记录导入
+		packageName = node.Name.Name
 		for _, imp := range node.Imports {
-			impPath := strings.Trim(imp.Path.Value, `"`)
-			packages[pkgPath].Imports = append(packages[pkgPath].Imports, impPath)
+			pos := fset.Position(imp.Path.Pos())
+			imports = append(imports, cachedImport{
+				Path:   strings.Trim(imp.Path.Value, `"`),
+				Line:   pos.Line,
+				Column: pos.Column,
+			})
 		}
 
-		return nil
-	})
+		cache.store(path, info, hash, cacheEntry{PackageName: packageName, TagExpr: tagExpr, Imports: imports})
+	}
+
+	isTestFile := strings.HasSuffix(info.Name(), "_test.go")
+	relPath, _ := filepath.Rel(modDir, filepath.Dir(path))
+	relPath = filepath.ToSlash(relPath)
+	pkgPath := fmt.Sprintf("%s/%s", modPrefix, relPath)
+	layer := getLayerForPackage(pkgPath, relPath, config)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := packages[pkgPath]; !exists {
+		packages[pkgPath] = newPackageInfo(pkgPath, modPrefix, layer)
+	}
+	targetPkg := packages[pkgPath]
+
+	// 外部测试包（package foo_test）对 import 而言是独立包：
+	// 落到 Path+"_test" 上，但沿用同一层归属。
+	if isTestFile && strings.HasSuffix(packageName, "_test") {
+		extPkgPath := pkgPath + "_test"
+		if _, exists := packages[extPkgPath]; !exists {
+			packages[extPkgPath] = newPackageInfo(extPkgPath, modPrefix, layer)
+		}
+		targetPkg = packages[extPkgPath]
+	}
+
+	// 记录导入：测试文件写入 Test* 字段，生产代码写入 Imports
+	for _, imp := range imports {
+		ref := ImportRef{
+			Path: imp.Path,
+			Pos:  token.Position{Filename: path, Line: imp.Line, Column: imp.Column},
+		}
+		if isTestFile {
+			targetPkg.TestImports = append(targetPkg.TestImports, ref)
+			targetPkg.TestImportsByTag[tagExpr] = append(targetPkg.TestImportsByTag[tagExpr], ref)
+		} else {
+			targetPkg.Imports = append(targetPkg.Imports, ref)
+			targetPkg.ImportsByTag[tagExpr] = append(targetPkg.ImportsByTag[tagExpr], ref)
+		}
+	}
 
-	return packages, err
+	return nil
 }
 
-// analyzePackages 完整实现层依赖分析
-func analyzePackages(packages map[string]*PackageInfo, config *Config) {
-	// 构建模块路径前缀集合
+// buildModulePrefixes 构建模块路径前缀集合：有 go.work 时以工作区的
+// 主模块为准，否则退化为从已解析包里收集到的模块路径。供 analyzePackages
+// 判断一个 import 是内部层还是外部依赖。
+func buildModulePrefixes(packages map[string]*PackageInfo, ws *Workspace) map[string]bool {
 	modulePrefixes := make(map[string]bool)
-	for _, pkg := range packages {
-		modulePrefixes[pkg.Module] = true
+	if ws != nil {
+		for _, m := range ws.MainModules {
+			modulePrefixes[m.Path] = true
+		}
+	} else {
+		for _, pkg := range packages {
+			modulePrefixes[pkg.Module] = true
+		}
 	}
+	return modulePrefixes
+}
+
+// analyzePackages 完整实现层依赖分析。跨工作区内多个模块的
+// 层/依赖规则同样生效：只要 import 能被归入某个 workspace 模块，
+// 它就被当作内部依赖参与 LayerDeps 统计，而不论它来自哪个成员模块。
+func analyzePackages(packages map[string]*PackageInfo, ws *Workspace, config *Config) {
+	modulePrefixes := buildModulePrefixes(packages, ws)
 
 	for _, pkg := range packages {
-		for _, imp := range pkg.Imports {
-			// 判断是否为外部依赖
-			isExternal := true
-			for module := range modulePrefixes {
-				if strings.HasPrefix(imp, module) {
-					isExternal = false
-					break
-				}
+		classifyImports(pkg, pkg.Imports, pkg.LayerDeps, pkg.ExternalDeps, packages, ws, modulePrefixes)
+		classifyImports(pkg, pkg.TestImports, pkg.TestLayerDeps, pkg.TestExternalDeps, packages, ws, modulePrefixes)
+	}
+}
+
+// classifyImports 将 imports 划分为外部依赖（写入 externalDeps）和层内依赖
+// （写入 layerDeps），供生产代码与测试代码共用同一套分类逻辑。每个 map
+// 为每个 layer/外部包只保留一个 ImportRef 用作违规报告的定位点，取
+// file:line:column 排序最小的那个（而不是先到先得）：parseProject 自
+// chunk0-6 起用 worker pool 并行解析文件，pkg.Imports 里条目的顺序每次
+// 运行都可能不同，先到先得会导致同一个 layer 违规在不同运行里报告不同的
+// 文件/行号，破坏 SARIF/JSON 输出在 CI 里逐次对比的可复现性。
+func classifyImports(pkg *PackageInfo, imports []ImportRef, layerDeps, externalDeps map[string]ImportRef, packages map[string]*PackageInfo, ws *Workspace, modulePrefixes map[string]bool) {
+	for _, ref := range imports {
+		imp := ref.Path
+
+		// replace 指令按模块路径重写 imp 的前缀；未命中任何 replace 条目时
+		// resolvedImp 就是 imp 本身。后续的外部/内部判断和 packages 查找
+		// 都必须用 resolvedImp，否则 replace 到工作区内部的 import 永远
+		// 只会按原始（通常是第三方）路径被归类。
+		resolvedImp := resolveImportPath(imp, ws)
+
+		// 判断是否为外部依赖
+		isExternal := true
+		for module := range modulePrefixes {
+			if strings.HasPrefix(resolvedImp, module) {
+				isExternal = false
+				break
 			}
+		}
 
-			if isExternal {
-				pkg.ExternalDeps[imp] = true
-				continue
+		if isExternal {
+			if existing, exists := externalDeps[resolvedImp]; !exists || refLess(ref, existing) {
+				externalDeps[resolvedImp] = ref
 			}
+			continue
+		}
 
-			// 查找被导入包的信息
-			if impPkg, exists := packages[imp]; exists {
-				pkg.LayerDeps[impPkg.Layer] = true
-				if impPkg.Layer == layerUnknown {
-					fmt.Printf("[warn] pkg `%s` imports UNKNOWN `%s`\n", pkg.Path, impPkg.Path)
-				}
+		// 查找被导入包的信息
+		if impPkg, exists := packages[resolvedImp]; exists {
+			if existing, exists := layerDeps[impPkg.Layer]; !exists || refLess(ref, existing) {
+				layerDeps[impPkg.Layer] = ref
+			}
+			if impPkg.Layer == layerUnknown {
+				fmt.Printf("[warn] pkg `%s` imports UNKNOWN `%s`\n", pkg.Path, impPkg.Path)
 			}
 		}
 	}
 }
 
-// checkDependencies 增强依赖检查
-func checkDependencies(packages map[string]*PackageInfo, config *Config) {
-	// 检查层依赖规则
+// refLess orders two ImportRefs by file, then line, then column, so the
+// "representative" ImportRef stored for a given layer/external dependency
+// is chosen deterministically rather than by whichever worker goroutine
+// happened to process it first.
+func refLess(a, b ImportRef) bool {
+	if a.Pos.Filename != b.Pos.Filename {
+		return a.Pos.Filename < b.Pos.Filename
+	}
+	if a.Pos.Line != b.Pos.Line {
+		return a.Pos.Line < b.Pos.Line
+	}
+	return a.Pos.Column < b.Pos.Column
+}
+
+// parseTagContexts 解析 --tag-contexts 的值：用逗号分隔出多个上下文，
+// 每个上下文内部可以用 "+" 再组合多个一起生效的 tag，例如
+// "cgo,windows+debug" 表示额外检查两个上下文：{cgo} 和 {windows, debug}。
+func parseTagContexts(s string) [][]string {
+	if s == "" {
+		return nil
+	}
+	var contexts [][]string
+	for _, group := range strings.Split(s, ",") {
+		contexts = append(contexts, strings.Split(group, "+"))
+	}
+	return contexts
+}
+
+// runTagContext re-parses and re-checks the whole project under buildCtx
+// with extraTags additionally active. A tag can gate a file's own
+// `//go:build` constraint, which buildCtx.MatchFile evaluates against
+// the active tag set at parse time (see processFile) — so a file gated
+// behind a tag not present in the *primary* run's context is never even
+// read, and its imports can't be recovered by re-classifying the primary
+// parse's ImportsByTag buckets after the fact. Re-running parseProject
+// per tag context is the only way to see those files, so that's what
+// this does; it costs a fresh walk+parse per context. The import cache
+// gives no hits here: configHash folds in the active build tags and the
+// cache holds one entry per file path, so each context's run overwrites
+// the entries of the previous one.
+func runTagContext(root string, config *Config, buildCtx *build.Context, extraTags []string, skipTests bool, jobs int, cacheDir string) ([]Violation, error) {
+	ctx := *buildCtx
+	ctx.BuildTags = append(append([]string{}, buildCtx.BuildTags...), extraTags...)
+
+	packages, ws, err := parseProject(root, config, &ctx, skipTests, jobs, cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse project for tag context %s: %v", strings.Join(extraTags, "+"), err)
+	}
+
+	analyzePackages(packages, ws, config)
+	graph := newLayerGraph(packages)
+	return checkDependencies(packages, config, activeTags(&ctx), skipTests, graph), nil
+}
+
+// checkDependencies 增强依赖检查。带 `when` 表达式的规则只在其在当前
+// 构建上下文（tags）下求值为真时才参与匹配，否则视为不存在该规则。
+// skipTests 为 true 时完全跳过测试代码的违规检查。graph 是跨全部包聚合出
+// 的层依赖图，用于 `transitive: true` 规则的可达性判断和层环检测。
+// 返回的 Violation 列表供 report.go 中按 --format 渲染为 text/json/sarif。
+func checkDependencies(packages map[string]*PackageInfo, config *Config, tags map[string]bool, skipTests bool, graph *LayerGraph) []Violation {
+	// test_dependency_rules 叠加在 dependency_rules 之上：测试专属规则优先匹配，
+	// 没有命中的再落回通用规则。
+	testRules := append(append([]DependencyRule{}, config.TestDependencyRules...), config.DependencyRules...)
+
+	var violations []Violation
 	for _, pkg := range packages {
-		for layer := range pkg.LayerDeps {
-			for _, rule := range config.DependencyRules {
-				if matchPattern(pkg.Layer, rule.From) && matchPattern(layer, rule.To) {
-					if !rule.Allow {
-						fmt.Printf("LAYER VIOLATION: %s (%s) -> %s\n",
-							pkg.Path, pkg.Layer, layer)
-					}
-					break
+		violations = append(violations, checkRuleSet(pkg, config.DependencyRules, tags, pkg.LayerDeps, pkg.ExternalDeps, false, true)...)
+		if !skipTests {
+			violations = append(violations, checkRuleSet(pkg, testRules, tags, pkg.TestLayerDeps, pkg.TestExternalDeps, true, false)...)
+		}
+	}
+
+	violations = append(violations, checkTransitiveRules(packages, config.DependencyRules, tags, graph)...)
+	violations = append(violations, checkLayerCycles(graph)...)
+
+	return violations
+}
+
+// checkRuleSet 对单个包的层依赖/外部依赖集合应用一组规则，返回命中的
+// Violation。debugLog 控制是否打印 [debug] 层依赖行，避免测试通道把
+// 生产通道的调试输出打印两遍。`transitive: true` 的规则在这里跳过，
+// 由 checkTransitiveRules 基于整张层依赖图统一判断。
+func checkRuleSet(pkg *PackageInfo, rules []DependencyRule, tags map[string]bool, layerDeps, externalDeps map[string]ImportRef, isTest bool, debugLog bool) []Violation {
+	var violations []Violation
+
+	for layer, ref := range layerDeps {
+		for _, rule := range rules {
+			if rule.Transitive {
+				continue
+			}
+			applies, err := evalWhen(rule.When, tags)
+			if err != nil {
+				fmt.Printf("[warn] skipping rule %s->%s: %v\n", rule.From, rule.To, err)
+				continue
+			}
+			if !applies {
+				continue
+			}
+			if matchPattern(pkg.Layer, rule.From) && matchPattern(layer, rule.To) {
+				if !rule.Allow {
+					violations = append(violations, newViolation("layer", isTest, pkg, rule, layer, ref))
 				}
+				break
 			}
+		}
+		if debugLog {
 			fmt.Printf("[debug] layer deps: %s (%s) -> %s\n", pkg.Path, pkg.Layer, layer)
 		}
+	}
 
-		// 检查外部依赖规则
-		for extPkg := range pkg.ExternalDeps {
-			for _, rule := range config.DependencyRules {
-				if matchPattern(pkg.Layer, rule.From) && matchPattern(extPkg, rule.To) {
-					if !rule.Allow {
-						fmt.Printf("EXTERNAL VIOLATION: %s (%s) -> %s\n",
-							pkg.Path, pkg.Layer, extPkg)
-					}
-					break
+	for extPkg, ref := range externalDeps {
+		for _, rule := range rules {
+			if rule.Transitive {
+				continue
+			}
+			applies, err := evalWhen(rule.When, tags)
+			if err != nil {
+				fmt.Printf("[warn] skipping rule %s->%s: %v\n", rule.From, rule.To, err)
+				continue
+			}
+			if !applies {
+				continue
+			}
+			if matchPattern(pkg.Layer, rule.From) && matchPattern(extPkg, rule.To) {
+				if !rule.Allow {
+					violations = append(violations, newViolation("external", isTest, pkg, rule, extPkg, ref))
+				}
+				break
+			}
+		}
+	}
+
+	return violations
+}
+
+// checkTransitiveRules 对每条 `transitive: true` 的规则，在层依赖图上用
+// BFS 判断任意一对匹配 from/to 模式的层之间是否可达，而不局限于直接边。
+func checkTransitiveRules(packages map[string]*PackageInfo, rules []DependencyRule, tags map[string]bool, graph *LayerGraph) []Violation {
+	var violations []Violation
+	layers := graph.nodes()
+
+	for _, rule := range rules {
+		if !rule.Transitive || rule.Allow {
+			continue
+		}
+		applies, err := evalWhen(rule.When, tags)
+		if err != nil {
+			fmt.Printf("[warn] skipping rule %s->%s: %v\n", rule.From, rule.To, err)
+			continue
+		}
+		if !applies {
+			continue
+		}
+
+		for _, from := range layers {
+			if !matchPattern(from, rule.From) {
+				continue
+			}
+			for _, to := range layers {
+				if !matchPattern(to, rule.To) || !graph.reachable(from, to) {
+					continue
 				}
+				violations = append(violations, Violation{
+					RuleID:     rule.From + "->" + rule.To,
+					Kind:       "layer",
+					Transitive: true,
+					Layer:      from,
+					Target:     to,
+					Package:    examplePackageForLayer(packages, from),
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+// examplePackageForLayer returns the lexicographically smallest package
+// path belonging to layer, used to give a transitive violation a concrete
+// starting point that does not depend on map iteration order.
+func examplePackageForLayer(packages map[string]*PackageInfo, layer string) string {
+	example := ""
+	for _, pkg := range packages {
+		if pkg.Layer == layer && (example == "" || pkg.Path < example) {
+			example = pkg.Path
+		}
+	}
+	return example
+}
+
+// cycleRuleID is the synthetic rule id attached to LAYER CYCLE violations,
+// which aren't triggered by any single configured DependencyRule. report.go
+// registers it as its own SARIF rule so results referencing it still
+// resolve to a driver.rules entry.
+const cycleRuleID = "layer-cycle"
+
+// checkLayerCycles runs Tarjan's SCC over the layer graph and reports
+// every strongly connected component of size > 1 as a LAYER CYCLE. Package
+// is filled in from graph.Examples so the violation points at a real
+// package exercising an edge from scc[0] to another layer of the component
+// (the first such layer by name), instead of being left blank.
+func checkLayerCycles(graph *LayerGraph) []Violation {
+	var violations []Violation
+	for _, scc := range graph.tarjanSCC() {
+		if len(scc) < 2 {
+			continue
+		}
+		example := ""
+		for _, to := range scc[1:] {
+			if graph.Edges[scc[0]][to] {
+				example = graph.Examples[scc[0]][to]
+				break
 			}
-			//fmt.Printf("[debug] exteranl deps: %s (%s) -> %s\n", pkg.Path, pkg.Layer, extPkg)
 		}
+		violations = append(violations, Violation{
+			RuleID:  cycleRuleID,
+			Kind:    "cycle",
+			Layer:   scc[0],
+			Target:  strings.Join(scc, " -> ") + " -> " + scc[0],
+			Package: example,
+		})
 	}
+	return violations
 }
 
 func getPackageInfo(path string) (string, error) {
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Violation 是一条已确认的依赖规则违规，携带足够的信息同时渲染为
+// 人类可读文本、JSON 和 SARIF 三种格式。
+type Violation struct {
+	RuleID     string `json:"ruleId"`     // 由 rule.From + "->" + rule.To 派生，cycle 违规固定为 cycleRuleID
+	Kind       string `json:"kind"`       // "layer"、"external" 或 "cycle"
+	IsTest     bool   `json:"isTest"`     // 是否来自 TEST LAYER/EXTERNAL VIOLATION 通道
+	Transitive bool   `json:"transitive"` // 是否由 `transitive: true` 规则的可达性判断触发
+	Package    string `json:"package"`    // 发起依赖的包路径（cycle 违规为层内的一个示例包）
+	Layer      string `json:"layer"`      // 发起依赖的包所在层
+	Target     string `json:"target"`     // 被依赖的层（kind=layer）、外部包（kind=external）或环路分量（kind=cycle：各层按名称排序后以 " -> " 连接并回到首层；分量超过两层时箭头不一定对应实际的边）
+	File       string `json:"file"`       // 触发该违规的 import 所在文件，cycle/transitive 违规为空
+	Line       int    `json:"line"`       // 1-based
+	Column     int    `json:"column"`     // 1-based
+	Context    string `json:"context"`    // 额外 tag 上下文的标签（"+"-joined tags），主上下文为空
+}
+
+// newViolation 依据匹配到的规则和触发该违规的 import 构造一个 Violation。
+func newViolation(kind string, isTest bool, pkg *PackageInfo, rule DependencyRule, target string, ref ImportRef) Violation {
+	return Violation{
+		RuleID:  rule.From + "->" + rule.To,
+		Kind:    kind,
+		IsTest:  isTest,
+		Package: pkg.Path,
+		Layer:   pkg.Layer,
+		Target:  target,
+		File:    ref.Pos.Filename,
+		Line:    ref.Pos.Line,
+		Column:  ref.Pos.Column,
+	}
+}
+
+// label 还原出原先文本报告里使用的 "LAYER VIOLATION" / "TEST EXTERNAL VIOLATION" 字样，
+// 并为第七批新增的 cycle/transitive 违规扩展出对应标签。
+func (v Violation) label() string {
+	if v.Kind == "cycle" {
+		return "LAYER CYCLE"
+	}
+
+	label := "LAYER VIOLATION"
+	if v.Kind == "external" {
+		label = "EXTERNAL VIOLATION"
+	}
+	if v.Transitive {
+		label = "TRANSITIVE " + label
+	}
+	if v.IsTest {
+		label = "TEST " + label
+	}
+	return label
+}
+
+// sortViolations 把 violations 按 context/package/kind/target/file/line/
+// column/ruleId 排序，使其与 checkDependencies 内部的 map 遍历顺序无关。main() 在分发
+// 给任意 --format 之前调用它一次，让同一次扫描在重复运行时产出逐字节
+// 相同的 text/json/sarif 输出，这对 chunk0-4 的 CI 报告/SARIF 仪表盘是
+// 前提条件。
+func sortViolations(violations []Violation) {
+	sort.Slice(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		if a.Context != b.Context {
+			return a.Context < b.Context
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return a.RuleID < b.RuleID
+	})
+}
+
+// reportText 保留原先的纯文本输出格式，逐条打印违规。
+func reportText(violations []Violation) {
+	for _, v := range violations {
+		fmt.Println(v.line())
+	}
+}
+
+// line 渲染一条违规的单行文本形式，text/sarif 报告共用。Context 非空时
+// （来自 build.tag_contexts / --tag-contexts 的额外上下文）追加标注，
+// 这样同一条层违规在不同 tag 组合下重复出现时仍能区分是哪个上下文触发的。
+func (v Violation) line() string {
+	suffix := ""
+	if v.Context != "" {
+		suffix = fmt.Sprintf(" [context=%s]", v.Context)
+	}
+	if v.Kind == "cycle" {
+		return fmt.Sprintf("%s: %s (example: %s)%s", v.label(), v.Target, v.Package, suffix)
+	}
+	return fmt.Sprintf("%s: %s (%s) -> %s%s", v.label(), v.Package, v.Layer, v.Target, suffix)
+}
+
+// reportJSON 以 JSON 数组的形式输出违规列表，便于喂给其他工具或仪表盘。
+func reportJSON(violations []Violation) error {
+	if violations == nil {
+		violations = []Violation{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(violations)
+}
+
+// SARIF 结构体只覆盖本工具需要的 SARIF 2.1.0 字段子集。
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+// buildSARIF 把违规列表转换为一个 sarif.Log：一个依赖规则对应一条 rule
+// 定义（rule id 取 from->to），每条违规对应一个 result，location 指向
+// 触发它的 import 所在的 .go 文件与位置。LAYER CYCLE 违规不对应任何单条
+// configured DependencyRule，也没有触发它的具体 import，所以额外注册
+// cycleRuleID 这条合成规则，并且不附带 locations（一个空 URI/0 行号的
+// location 会被大多数 SARIF 消费方——包括 GitHub code scanning——拒绝）。
+func buildSARIF(rules []DependencyRule, violations []Violation) sarifLog {
+	driver := sarifDriver{Name: "go-archiguard"}
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		id := rule.From + "->" + rule.To
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		driver.Rules = append(driver.Rules, sarifRule{ID: id})
+	}
+	for _, v := range violations {
+		if v.Kind == "cycle" && !seen[v.RuleID] {
+			seen[v.RuleID] = true
+			driver.Rules = append(driver.Rules, sarifRule{ID: v.RuleID})
+		}
+	}
+
+	results := make([]sarifResult, 0, len(violations))
+	for _, v := range violations {
+		result := sarifResult{
+			RuleID:  v.RuleID,
+			Level:   "error",
+			Message: sarifMessage{Text: v.line()},
+		}
+		if v.File != "" {
+			result.Locations = []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: v.File},
+					Region:           sarifRegion{StartLine: v.Line, StartColumn: v.Column},
+				},
+			}}
+		}
+		results = append(results, result)
+	}
+
+	return sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: driver},
+			Results: results,
+		}},
+	}
+}
+
+// reportSARIF 输出 violations 对应的 SARIF 2.1.0 日志。
+func reportSARIF(rules []DependencyRule, violations []Violation) error {
+	log := buildSARIF(rules, violations)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}